@@ -0,0 +1,257 @@
+// Package ui implements the full-screen terminal UI that replaces the
+// scrolling stdout printer when stdout is a TTY. It knows nothing about
+// packet capture: the caller (package main) supplies a DataSource that
+// pulls live rows from the shared stats and pushes control changes (sort,
+// direction, grouping, filter) back into the capture loop.
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// GroupMode mirrors the app's aggregation dimension, extended with
+// GroupProcess for the 'p' key to cycle through.
+type GroupMode int
+
+const (
+	GroupIP GroupMode = iota
+	GroupIPPort
+	GroupProcess
+	GroupASN
+)
+
+func (g GroupMode) String() string {
+	switch g {
+	case GroupIPPort:
+		return "ip:port"
+	case GroupProcess:
+		return "process"
+	case GroupASN:
+		return "asn"
+	default:
+		return "ip"
+	}
+}
+
+// Direction mirrors which side of the capture is being shown.
+type Direction int
+
+const (
+	DirectionOutbound Direction = iota
+	DirectionInbound
+	DirectionBoth
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionBoth:
+		return "both"
+	default:
+		return "outbound"
+	}
+}
+
+// Row is one line of the live top-talkers table.
+type Row struct {
+	Label       string
+	BytesTotal  uint64
+	BytesPerSec uint64
+	ActiveConns int
+	Geo         string
+}
+
+// FlowRow is one row of the Enter drill-down for a selected Row.
+type FlowRow struct {
+	RemotePort uint16
+	Proto      string
+	LastSeen   time.Time
+	Bytes      uint64
+}
+
+// DataSource is how the TUI pulls live data and pushes control changes back
+// to the capture loop, without this package importing package main.
+type DataSource interface {
+	// Rows returns the current top-N rows, already sorted and limited the
+	// same way the plain printer would, restricted to those matching
+	// filter (a case-insensitive substring against label/geo; "" matches
+	// everything).
+	Rows(filter string) []Row
+	// Flows returns the per-flow breakdown for the prefix/label behind a
+	// selected Row, for the Enter drill-down.
+	Flows(label string) []FlowRow
+	ToggleSort()
+	SetDirection(Direction)
+	SetGroupMode(GroupMode)
+}
+
+// Config configures Run.
+type Config struct {
+	// RefreshInterval is how often the table repaints from DataSource.
+	RefreshInterval time.Duration
+	// InitialDirection and InitialGroupMode seed the header and the 'd'/'p'
+	// cycling with whatever the capture loop is already doing (from
+	// -inbound/-group-by), so they don't misreport the active mode or make
+	// the first keypress a no-op or a jump to the wrong mode. GroupMode has
+	// no proto value, so a capture loop grouping by proto is seeded as
+	// GroupIP.
+	InitialDirection Direction
+	InitialGroupMode GroupMode
+}
+
+// Run starts the full-screen TUI and blocks until the user quits with 'q'.
+// It's the natural endpoint for the raw-input scaffolding: handleRawInput/
+// makeRaw/restore remain the fallback for non-TTY stdout (-output plain, or
+// piping to a file/log).
+func Run(ds DataSource, cfg Config) error {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = time.Second
+	}
+
+	app := tview.NewApplication()
+
+	direction := cfg.InitialDirection
+	group := cfg.InitialGroupMode
+	filter := ""
+
+	table := tview.NewTable().SetBorders(false).SetSelectable(true, false).SetFixed(1, 0)
+	header := tview.NewTextView().SetDynamicColors(true)
+
+	pages := tview.NewPages()
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(header, 1, 0, false).
+		AddItem(table, 0, 1, true)
+	pages.AddPage("main", root, true, true)
+
+	var rows []Row
+
+	renderHeader := func() {
+		fmt.Fprintf(header, "[::b]chitose[::-]  direction=%s  group=%s  filter=%q  (s sort, d direction, p group, / filter, Enter drill down, q quit)",
+			direction, group, filter)
+	}
+
+	renderTable := func() {
+		rows = ds.Rows(filter)
+		table.Clear()
+		cols := []string{"PREFIX", "GEO", "TOTAL", "RATE/s", "CONNS"}
+		for c, title := range cols {
+			table.SetCell(0, c, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		}
+		for r, row := range rows {
+			table.SetCell(r+1, 0, tview.NewTableCell(row.Label))
+			table.SetCell(r+1, 1, tview.NewTableCell(row.Geo))
+			table.SetCell(r+1, 2, tview.NewTableCell(humanize.IBytes(row.BytesTotal)))
+			table.SetCell(r+1, 3, tview.NewTableCell(humanize.IBytes(row.BytesPerSec)))
+			table.SetCell(r+1, 4, tview.NewTableCell(fmt.Sprintf("%d", row.ActiveConns)))
+		}
+	}
+
+	redraw := func() {
+		header.Clear()
+		renderHeader()
+		renderTable()
+	}
+
+	showFlows := func(label string) {
+		flows := ds.Flows(label)
+		detail := tview.NewTable().SetBorders(false).SetSelectable(false, false)
+		detail.SetCell(0, 0, tview.NewTableCell("PORT").SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		detail.SetCell(0, 1, tview.NewTableCell("PROTO").SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		detail.SetCell(0, 2, tview.NewTableCell("LAST SEEN").SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		detail.SetCell(0, 3, tview.NewTableCell("BYTES").SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		for i, f := range flows {
+			detail.SetCell(i+1, 0, tview.NewTableCell(fmt.Sprintf("%d", f.RemotePort)))
+			detail.SetCell(i+1, 1, tview.NewTableCell(f.Proto))
+			detail.SetCell(i+1, 2, tview.NewTableCell(f.LastSeen.Format("15:04:05")))
+			detail.SetCell(i+1, 3, tview.NewTableCell(humanize.IBytes(f.Bytes)))
+		}
+		detail.SetTitle(fmt.Sprintf(" %s ", label)).SetBorder(true)
+		detail.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			if event.Key() == tcell.KeyEsc || event.Rune() == 'q' {
+				pages.RemovePage("detail")
+				app.SetFocus(table)
+				return nil
+			}
+			return event
+		})
+		pages.AddPage("detail", detail, true, true)
+		app.SetFocus(detail)
+	}
+
+	showFilterInput := func() {
+		input := tview.NewInputField().SetLabel("/ ").SetText(filter)
+		input.SetDoneFunc(func(key tcell.Key) {
+			if key == tcell.KeyEnter {
+				filter = input.GetText()
+			}
+			pages.RemovePage("filter")
+			app.SetFocus(table)
+			redraw()
+		})
+		modal := tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(input, 1, 0, true).
+			AddItem(nil, 0, 1, false)
+		pages.AddPage("filter", modal, true, true)
+		app.SetFocus(input)
+	}
+
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if pages.HasPage("filter") || pages.HasPage("detail") {
+			return event
+		}
+		switch event.Rune() {
+		case 's':
+			ds.ToggleSort()
+			return nil
+		case 'd':
+			direction = (direction + 1) % 3
+			ds.SetDirection(direction)
+			redraw()
+			return nil
+		case 'p':
+			group = (group + 1) % 4
+			ds.SetGroupMode(group)
+			redraw()
+			return nil
+		case '/':
+			showFilterInput()
+			return nil
+		case 'q':
+			app.Stop()
+			return nil
+		}
+		if event.Key() == tcell.KeyEnter {
+			row, _ := table.GetSelection()
+			if row >= 1 && row-1 < len(rows) {
+				showFlows(rows[row-1].Label)
+			}
+			return nil
+		}
+		return event
+	})
+
+	redraw()
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cfg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				app.QueueUpdateDraw(redraw)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer close(stop)
+
+	return app.SetRoot(pages, true).SetFocus(table).Run()
+}
@@ -1,65 +1,240 @@
 package main
 
 import (
+	"container/list"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Mythologyli/chitose/internal/ui"
 	"github.com/cakturk/go-netstat/netstat"
 	"github.com/dustin/go-humanize"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/ipipdotnet/ipdb-go"
+	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/term"
 )
 
 type InterfaceInfo struct {
-	MAC net.HardwareAddr
-	IPs []net.IP
+	MAC  net.HardwareAddr
+	IPs  []net.IP
+	Nets []*net.IPNet
 }
 
-var db *ipdb.City
+// geo is the configured GeoProvider (nil if no geo database was given).
+var geo GeoProvider
+var geoCache = newGeoLRU(4096)
 
 var topShow *int
 
 var noNetstat *bool
 var useInbound *bool
+var byProcess *bool
 
-var deltaStats map[string]uint64
-var sizeStats map[string]uint64
+var deltaStats map[StatKey]uint64
+var sizeStats map[StatKey]uint64
 var statLock sync.Mutex
 var printTimestamp time.Time
 
+// groupBy selects which dimensions make up a StatKey. It starts out set by
+// the -group-by flag, but the TUI's 'p' key can also change it at runtime,
+// so reads/writes go through groupByMutex.
+var groupBy GroupBy
+var groupByMutex sync.Mutex
+
+func currentGroupBy() GroupBy {
+	groupByMutex.Lock()
+	defer groupByMutex.Unlock()
+	return groupBy
+}
+
+// setGroupBy changes the aggregation mode. sizeStats/deltaStats are keyed by
+// the previous mode's StatKey, so a real change resets them (and the
+// per-process procBytes/procDestBytes along with them); otherwise old
+// entries would persist, sort to the
+// top under their large stale totals, and render as garbage under the new
+// mode's StatKey.String() (e.g. a blank label in asn mode, "other" in proto
+// mode, "prefix:0" in ip:port mode).
+func setGroupBy(g GroupBy) {
+	groupByMutex.Lock()
+	changed := groupBy != g
+	groupBy = g
+	groupByMutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	statLock.Lock()
+	deltaStats = make(map[StatKey]uint64)
+	sizeStats = make(map[StatKey]uint64)
+	statLock.Unlock()
+
+	procStatLock.Lock()
+	procBytes = make(map[string]uint64)
+	procDestBytes = make(map[string]map[string]uint64)
+	procStatLock.Unlock()
+}
+
+// processModeEnabled reports whether traffic should be attributed to
+// processes instead of prefixes, either via the static -by-process flag or
+// the TUI's 'p' key having cycled groupBy to GroupByProcess.
+func processModeEnabled() bool {
+	return *byProcess || currentGroupBy() == GroupByProcess
+}
+
+// Direction selects which side of the capture loop counts: outbound only,
+// inbound only, or both. It starts out set by the -inbound flag, but the
+// TUI's 'd' key can also change it at runtime, so reads/writes go through
+// captureDirectionMutex.
+type Direction int
+
+const (
+	DirectionOutbound Direction = iota
+	DirectionInbound
+	DirectionBoth
+)
+
+var captureDirection Direction
+var captureDirectionMutex sync.Mutex
+
+func currentDirection() Direction {
+	captureDirectionMutex.Lock()
+	defer captureDirectionMutex.Unlock()
+	return captureDirection
+}
+
+func setDirection(d Direction) {
+	captureDirectionMutex.Lock()
+	captureDirection = d
+	captureDirectionMutex.Unlock()
+}
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionBoth:
+		return "both"
+	default:
+		return "outbound"
+	}
+}
+
 var boldStart = "\u001b[1m"
 var boldEnd = "\u001b[22m"
 
 var sortByTotal = true
 var sortByTotalMutex sync.Mutex
 
-func getInterfaceAddrs(ifaceName string) (info InterfaceInfo, err error) {
-	info = InterfaceInfo{}
-	info.IPs = make([]net.IP, 0)
+// offlineMode is set when packets are replayed from -r instead of captured
+// live, so printing is driven by packet timestamps rather than wall clock.
+var offlineMode bool
+var lastWindowTime time.Time
+
+// startTime is the process start, used for the /status uptime field.
+var startTime time.Time
+
+// globalStats is the single Stats instance shared by the terminal printer
+// and the HTTP status/metrics endpoints, so they render one consistent view
+// instead of each re-deriving it from the raw maps.
+var globalStats = &Stats{}
+
+// GroupBy selects which dimensions are aggregated into a StatKey.
+type GroupBy int
+
+const (
+	GroupByIP GroupBy = iota
+	GroupByIPPort
+	GroupByProto
+	GroupByASN
+	GroupByProcess
+)
+
+func parseGroupBy(s string) GroupBy {
+	switch s {
+	case "ip:port":
+		return GroupByIPPort
+	case "proto":
+		return GroupByProto
+	case "asn":
+		return GroupByASN
+	case "process":
+		return GroupByProcess
+	default:
+		return GroupByIP
+	}
+}
+
+// StatKey is the aggregation key for deltaStats/sizeStats. Which fields are
+// populated depends on groupBy: Prefix (the /24 or /48 of the remote IP) is
+// set in the ip, ip:port and process modes, Proto is set in the proto and
+// ip:port modes, Port only in the ip:port mode, and ASN only in the asn mode
+// (which collapses every prefix in the same AS into one row; it's "unknown"
+// without a GeoProvider that resolves ASNs).
+type StatKey struct {
+	Prefix string
+	Proto  string
+	Port   uint16
+	ASN    string
+}
+
+func (k StatKey) String() string {
+	switch currentGroupBy() {
+	case GroupByProto:
+		if k.Proto == "" {
+			return "other"
+		}
+		return k.Proto
+	case GroupByIPPort:
+		if k.Proto != "" {
+			return fmt.Sprintf("%s:%d/%s", k.Prefix, k.Port, k.Proto)
+		}
+		return fmt.Sprintf("%s:%d", k.Prefix, k.Port)
+	case GroupByASN:
+		return k.ASN
+	default:
+		return k.Prefix
+	}
+}
+
+// getInterfacesAddrs returns the MAC/IPs of each named interface, keyed by
+// interface name, so live capture can run several interfaces at once.
+func getInterfacesAddrs(ifaceNames []string) (map[string]InterfaceInfo, error) {
+	wanted := make(map[string]bool, len(ifaceNames))
+	for _, name := range ifaceNames {
+		wanted[name] = true
+	}
 
+	result := make(map[string]InterfaceInfo)
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return info, err
+		return nil, err
 	}
 	for _, iface := range ifaces {
-		if iface.Name == ifaceName {
-			info.MAC = iface.HardwareAddr
+		if !wanted[iface.Name] {
+			continue
+		}
 
-			addrs, err := iface.Addrs()
-			if err != nil {
-				log.Printf("Error getting addresses for interface %s: %s\n", iface.Name, err)
-				continue
-			}
+		info := InterfaceInfo{IPs: make([]net.IP, 0)}
+		info.MAC = iface.HardwareAddr
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Printf("Error getting addresses for interface %s: %s\n", iface.Name, err)
+		} else {
 			for _, addr := range addrs {
 				switch v := addr.(type) {
 				case *net.IPNet:
@@ -69,24 +244,226 @@ func getInterfaceAddrs(ifaceName string) (info InterfaceInfo, err error) {
 				}
 			}
 		}
+		result[iface.Name] = info
+	}
+	return result, nil
+}
+
+// autoSelectInterface picks the first up, non-loopback interface with an
+// IPv4 address, used when -i isn't given.
+func autoSelectInterface() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+			if ip != nil && ip.To4() != nil {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no non-loopback interface with an IPv4 address found, pass -i explicitly")
+}
+
+// resolveInterfaceNames turns the -i flag into a concrete list of interfaces
+// to capture on: a comma-separated list as given, "any" expanded to every
+// non-loopback device pcap can see, or (when empty) the auto-selected
+// default interface.
+func resolveInterfaceNames(ifaceFlag string) ([]string, error) {
+	if ifaceFlag == "" {
+		name, err := autoSelectInterface()
+		if err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	}
+
+	if ifaceFlag == "any" {
+		devices, err := pcap.FindAllDevs()
+		if err != nil {
+			return nil, err
+		}
+		loopback := make(map[string]bool)
+		if ifaces, err := net.Interfaces(); err == nil {
+			for _, iface := range ifaces {
+				if iface.Flags&net.FlagLoopback != 0 {
+					loopback[iface.Name] = true
+				}
+			}
+		}
+
+		var names []string
+		for _, dev := range devices {
+			if loopback[dev.Name] || len(dev.Addresses) == 0 {
+				continue
+			}
+			names = append(names, dev.Name)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no usable interfaces found for -i any")
+		}
+		return names, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(ifaceFlag, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// printDeviceList prints every interface pcap can see, mirroring `tcpdump -D`.
+func printDeviceList() {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, dev := range devices {
+		if dev.Description != "" {
+			fmt.Printf("%s (%s)\n", dev.Name, dev.Description)
+		} else {
+			fmt.Println(dev.Name)
+		}
+		for _, addr := range dev.Addresses {
+			fmt.Printf("\t%s\n", addr.IP)
+		}
 	}
-	return info, nil
 }
 
 func isOutbound(info InterfaceInfo, linkFlow gopacket.Flow, networkFlow gopacket.Flow) bool {
 	if info.MAC != nil && linkFlow != (gopacket.Flow{}) {
 		return linkFlow.Src().String() == info.MAC.String()
 	}
-	if len(info.IPs) > 0 && networkFlow != (gopacket.Flow{}) {
-		for _, ip := range info.IPs {
-			if networkFlow.Src().String() == ip.String() {
-				return true
+	if networkFlow == (gopacket.Flow{}) {
+		return false
+	}
+	for _, ip := range info.IPs {
+		if networkFlow.Src().String() == ip.String() {
+			return true
+		}
+	}
+	if len(info.Nets) > 0 {
+		srcIP := net.ParseIP(networkFlow.Src().String())
+		if srcIP != nil {
+			for _, ipNet := range info.Nets {
+				if ipNet.Contains(srcIP) {
+					return true
+				}
 			}
 		}
 	}
 	return false
 }
 
+// listOfflineSources resolves -r into a sorted list of pcap/pcapng files to
+// replay in order. A plain file is replayed as-is; a directory is scanned
+// (non-recursively) for *.pcap and *.pcapng files.
+func listOfflineSources(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if strings.HasSuffix(name, ".pcap") || strings.HasSuffix(name, ".pcapng") {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// selfAddrInfo builds the InterfaceInfo used as "our side" when replaying
+// offline captures, since there's no live interface to inspect.
+func selfAddrInfo(selfIPs, selfCIDRs string) InterfaceInfo {
+	info := InterfaceInfo{}
+	for _, s := range strings.Split(selfIPs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			log.Printf("Invalid -self-ip %q\n", s)
+			continue
+		}
+		info.IPs = append(info.IPs, ip)
+	}
+	for _, s := range strings.Split(selfCIDRs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Printf("Invalid -self-cidr %q: %s\n", s, err)
+			continue
+		}
+		info.Nets = append(info.Nets, ipNet)
+	}
+	return info
+}
+
+// applyBPFFilter compiles and installs filter on handle, if one was given.
+func applyBPFFilter(handle *pcap.Handle, filter string) {
+	if filter == "" {
+		return
+	}
+	if err := handle.SetBPFFilter(filter); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// advanceOfflineWindow drives the 5s aggregation window off packet
+// timestamps instead of wall clock, since a replayed capture has no
+// real-time relationship to when it's being analyzed.
+func advanceOfflineWindow(ts time.Time) {
+	if lastWindowTime.IsZero() {
+		lastWindowTime = ts
+		printTimestamp = ts
+		return
+	}
+	if ts.Sub(lastWindowTime) >= 5*time.Second {
+		if processModeEnabled() {
+			printTopProcesses()
+		} else {
+			printTopValues(ts)
+		}
+		fmt.Println()
+		lastWindowTime = ts
+	}
+}
+
 func getIPPrefixString(ip netip.Addr) string {
 	var clientPrefix netip.Prefix
 	if ip.Is4() {
@@ -98,48 +475,45 @@ func getIPPrefixString(ip netip.Addr) string {
 	return clientPrefix.String()
 }
 
-func printTopValues() {
-	var keys []string
-	activeConn := make(map[string]int)
+// StatEntry is one row of a Stats snapshot: a StatKey with its aggregated
+// totals and the extra context (active connections, geo) shown alongside it.
+type StatEntry struct {
+	Key         StatKey
+	Total       uint64
+	Delta       uint64
+	BytesPerSec uint64
+	ActiveConns int
+	Geo         string
+	ASN         string
+}
 
-	if !*noNetstat {
-		// Get active connections
-		tabs, err := netstat.TCPSocks(func(s *netstat.SockTabEntry) bool {
-			return s.State == netstat.Established
-		})
-		if err != nil {
-			log.Printf("netstat error: %v", err)
-		} else {
-			for _, tab := range tabs {
-				ip, ok := netip.AddrFromSlice(tab.RemoteAddr.IP)
-				if !ok {
-					continue
-				}
-				activeConn[getIPPrefixString(ip)] += 1
-			}
-		}
-		tabs, err = netstat.TCP6Socks(func(s *netstat.SockTabEntry) bool {
-			return s.State == netstat.Established
-		})
-		if err != nil {
-			log.Printf("netstat error: %v", err)
-		} else {
-			for _, tab := range tabs {
-				ip, ok := netip.AddrFromSlice(tab.RemoteAddr.IP)
-				if !ok {
-					continue
-				}
-				activeConn[getIPPrefixString(ip)] += 1
-			}
-		}
+// Stats holds the most recently computed top-N view, shared by the terminal
+// printer and the HTTP status/metrics endpoints so both render the same
+// data instead of each re-deriving it from sizeStats/deltaStats.
+type Stats struct {
+	mu      sync.Mutex
+	entries []StatEntry
+}
+
+// Snapshot folds the accumulated delta into the running totals - the
+// once-per-window tick, previously inlined in printTopValues - and returns
+// the sorted top-N entries. Call it once per aggregation window (from
+// printStats or advanceOfflineWindow); use Cached for reads in between.
+func (s *Stats) Snapshot(now time.Time) []StatEntry {
+	activeConn := activeConnections()
+
+	duration := now.Sub(printTimestamp)
+	printTimestamp = now
+	secs := uint64(duration.Seconds())
+	if secs == 0 {
+		secs = 1
 	}
 
-	duration := time.Since(printTimestamp)
-	printTimestamp = time.Now()
 	statLock.Lock()
 	for k, v := range deltaStats {
 		sizeStats[k] += v
 	}
+	var keys []StatKey
 	for k := range sizeStats {
 		keys = append(keys, k)
 	}
@@ -164,36 +538,592 @@ func printTopValues() {
 		top = len(keys)
 	}
 
-	delta := make(map[string]uint64)
+	entries := make([]StatEntry, 0, top)
 	for i := 0; i < top; i++ {
 		key := keys[i]
-		delta[key] = deltaStats[key]
+
+		// An ASN-grouped key has no single Prefix to look geo up by (it
+		// already collapses every prefix in the AS together), so its ASN
+		// label comes straight from the key instead of a fresh lookup.
+		var entryGeo, entryASN string
+		if currentGroupBy() == GroupByASN {
+			entryASN = key.ASN
+		} else {
+			g := lookupGeo(key.Prefix)
+			entryGeo = formatGeoLabel(g)
+			entryASN = g.asn
+		}
+
+		entries = append(entries, StatEntry{
+			Key:         key,
+			Total:       sizeStats[key],
+			Delta:       deltaStats[key],
+			BytesPerSec: deltaStats[key] / secs,
+			ActiveConns: activeConn[key.Prefix],
+			Geo:         entryGeo,
+			ASN:         entryASN,
+		})
 	}
-	deltaStats = make(map[string]uint64)
+	deltaStats = make(map[StatKey]uint64)
 	statLock.Unlock()
 
-	for i := 0; i < top; i++ {
-		key := keys[i]
-		total := sizeStats[key]
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
 
-		connection := ""
-		if !*noNetstat {
-			if _, ok := activeConn[key]; ok {
-				activeString := fmt.Sprintf(" (active, %d)", activeConn[key])
-				connection = fmt.Sprintf("%s%s%s", boldStart, activeString, boldEnd)
+	return entries
+}
+
+// Cached returns the entries computed by the most recent Snapshot call,
+// without rolling the counters again. The HTTP endpoints use this so a
+// scrape between ticks doesn't reset deltaStats out from under the
+// terminal printer's own cadence.
+func (s *Stats) Cached() []StatEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+// activeConnections counts established connections per remote /24 or /48
+// prefix, via netstat.
+func activeConnections() map[string]int {
+	activeConn := make(map[string]int)
+	if *noNetstat {
+		return activeConn
+	}
+
+	tabs, err := netstat.TCPSocks(func(s *netstat.SockTabEntry) bool {
+		return s.State == netstat.Established
+	})
+	if err != nil {
+		log.Printf("netstat error: %v", err)
+	} else {
+		for _, tab := range tabs {
+			ip, ok := netip.AddrFromSlice(tab.RemoteAddr.IP)
+			if !ok {
+				continue
+			}
+			activeConn[getIPPrefixString(ip)] += 1
+		}
+	}
+	tabs, err = netstat.TCP6Socks(func(s *netstat.SockTabEntry) bool {
+		return s.State == netstat.Established
+	})
+	if err != nil {
+		log.Printf("netstat error: %v", err)
+	} else {
+		for _, tab := range tabs {
+			ip, ok := netip.AddrFromSlice(tab.RemoteAddr.IP)
+			if !ok {
+				continue
 			}
+			activeConn[getIPPrefixString(ip)] += 1
+		}
+	}
+	return activeConn
+}
+
+// GeoProvider resolves an IP to geographic and network-ownership info. A
+// provider leaves a field "" when it doesn't carry that information (e.g.
+// the MaxMind ASN database only ever returns asn/org).
+type GeoProvider interface {
+	Lookup(ip string) (country, region, city, asn, org string)
+}
+
+// ipdbGeoProvider looks up city-level geo via ipipdotnet/ipdb-go.
+type ipdbGeoProvider struct {
+	db *ipdb.City
+}
+
+func (p *ipdbGeoProvider) Lookup(ip string) (country, region, city, asn, org string) {
+	res, err := p.db.FindInfo(ip, "CN")
+	if err != nil {
+		return "", "", "", "", ""
+	}
+	return res.CountryName, res.RegionName, res.CityName, "", ""
+}
+
+// maxmindCityProvider looks up city-level geo via a MaxMind GeoLite2-City.mmdb.
+type maxmindCityProvider struct {
+	db *geoip2.Reader
+}
+
+func (p *maxmindCityProvider) Lookup(ip string) (country, region, city, asn, org string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", "", "", ""
+	}
+	record, err := p.db.City(parsed)
+	if err != nil {
+		return "", "", "", "", ""
+	}
+	country = record.Country.Names["en"]
+	if len(record.Subdivisions) > 0 {
+		region = record.Subdivisions[0].Names["en"]
+	}
+	city = record.City.Names["en"]
+	return country, region, city, "", ""
+}
+
+// maxmindASNProvider looks up the owning network via a MaxMind GeoLite2-ASN.mmdb.
+type maxmindASNProvider struct {
+	db *geoip2.Reader
+}
+
+func (p *maxmindASNProvider) Lookup(ip string) (country, region, city, asn, org string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", "", "", ""
+	}
+	record, err := p.db.ASN(parsed)
+	if err != nil {
+		return "", "", "", "", ""
+	}
+	return "", "", "", fmt.Sprintf("AS%d", record.AutonomousSystemNumber), record.AutonomousSystemOrganization
+}
+
+// multiGeoProvider combines several providers, e.g. a city database for
+// country/region/city plus an ASN database for asn/org. Earlier providers
+// win when two both set the same field.
+type multiGeoProvider struct {
+	providers []GeoProvider
+}
+
+func (p *multiGeoProvider) Lookup(ip string) (country, region, city, asn, org string) {
+	for _, provider := range p.providers {
+		c, r, ci, a, o := provider.Lookup(ip)
+		if country == "" {
+			country = c
+		}
+		if region == "" {
+			region = r
+		}
+		if city == "" {
+			city = ci
+		}
+		if asn == "" {
+			asn = a
+		}
+		if org == "" {
+			org = o
+		}
+	}
+	return country, region, city, asn, org
+}
+
+// buildGeoProvider assembles the configured GeoProvider from whichever of
+// -geo-ipdb/-geo-mmdb/-geo-asn were given; the ASN database stacks with
+// either city database. Returns nil if none were given.
+func buildGeoProvider(ipdbPath, mmdbPath, asnPath string) GeoProvider {
+	var providers []GeoProvider
+
+	if ipdbPath != "" {
+		db, err := ipdb.NewCity(ipdbPath)
+		if err != nil {
+			log.Printf("Error opening IPDB %s: %s\n", ipdbPath, err)
+		} else {
+			providers = append(providers, &ipdbGeoProvider{db: db})
+		}
+	}
+	if mmdbPath != "" {
+		db, err := geoip2.Open(mmdbPath)
+		if err != nil {
+			log.Printf("Error opening GeoLite2 City database %s: %s\n", mmdbPath, err)
+		} else {
+			providers = append(providers, &maxmindCityProvider{db: db})
+		}
+	}
+	if asnPath != "" {
+		db, err := geoip2.Open(asnPath)
+		if err != nil {
+			log.Printf("Error opening GeoLite2 ASN database %s: %s\n", asnPath, err)
+		} else {
+			providers = append(providers, &maxmindASNProvider{db: db})
 		}
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil
+	case 1:
+		return providers[0]
+	default:
+		return &multiGeoProvider{providers: providers}
+	}
+}
+
+// geoResult is a cached Lookup result.
+type geoResult struct {
+	country, region, city, asn, org string
+}
 
-		ipLocation := ""
-		if db != nil {
-			ipStr := strings.Split(key, "/")[0]
-			res, err := db.FindInfo(ipStr, "CN")
-			if err != nil {
-				ipLocation = fmt.Sprintf("[%s %s %s]", res.CountryName, res.RegionName, res.CityName)
+// geoLRU caches geoResult by /24 or /48 prefix, since a prefix gets looked
+// up again every aggregation window.
+type geoLRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type geoLRUEntry struct {
+	key   string
+	value geoResult
+}
+
+func newGeoLRU(capacity int) *geoLRU {
+	return &geoLRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *geoLRU) Get(key string) (geoResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return geoResult{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*geoLRUEntry).value, true
+}
+
+func (c *geoLRU) Put(key string, value geoResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*geoLRUEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	el := c.order.PushFront(&geoLRUEntry{key: key, value: value})
+	c.items[key] = el
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*geoLRUEntry).key)
+		}
+	}
+}
+
+// lookupGeo resolves prefix through geoCache, falling back to geo.Lookup on
+// a cache miss. Returns the zero value when no GeoProvider is configured.
+func lookupGeo(prefix string) geoResult {
+	if geo == nil || prefix == "" {
+		return geoResult{}
+	}
+	if cached, ok := geoCache.Get(prefix); ok {
+		return cached
+	}
+	country, region, city, asn, org := geo.Lookup(strings.Split(prefix, "/")[0])
+	result := geoResult{country: country, region: region, city: city, asn: asn, org: org}
+	geoCache.Put(prefix, result)
+	return result
+}
+
+// formatGeoLabel renders a geoResult the way the terminal output expects:
+// "[country region city asn org]", trimmed down when some fields are empty.
+func formatGeoLabel(g geoResult) string {
+	fields := []string{g.country, g.region, g.city, g.asn, g.org}
+	var nonEmpty []string
+	for _, f := range fields {
+		if f != "" {
+			nonEmpty = append(nonEmpty, f)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[%s]", strings.Join(nonEmpty, " "))
+}
+
+// flowKey identifies a single socket, the same way the kernel's connection
+// table does: local and remote endpoint plus transport protocol.
+type flowKey struct {
+	LocalIP    string
+	LocalPort  uint16
+	RemoteIP   string
+	RemotePort uint16
+	Proto      string
+}
+
+// processInfo is the owning process of a flowKey, as reported by netstat.
+type processInfo struct {
+	PID  int
+	UID  uint32
+	Name string
+}
+
+// flowProcess maps live sockets to their owning process; it's rebuilt every
+// 2s by refreshFlowProcessLoop since sockets come and go. Only populated
+// once process mode is enabled, whether from -by-process/-group-by process
+// at startup or the TUI's 'p' key switching into it later.
+var flowProcess map[flowKey]processInfo
+var flowProcessLock sync.RWMutex
+var flowProcessLoopOnce sync.Once
+
+// ensureFlowProcessLoop starts refreshFlowProcessLoop the first time process
+// mode is needed, so switching into it at runtime (via the TUI) still
+// populates flowProcess.
+func ensureFlowProcessLoop() {
+	flowProcessLoopOnce.Do(func() {
+		go refreshFlowProcessLoop()
+	})
+}
+
+// procBytes and procDestBytes are the -by-process counterparts of
+// sizeStats: total bytes per process, and per process the destination
+// prefixes it talked to.
+var procBytes map[string]uint64
+var procDestBytes map[string]map[string]uint64
+var procStatLock sync.Mutex
+
+// flowDetailKey identifies a remote port/protocol pair seen for a prefix,
+// the unit the TUI's Enter drill-down breaks a selected row down into.
+type flowDetailKey struct {
+	Prefix string
+	Port   uint16
+	Proto  string
+}
+
+type flowDetail struct {
+	Bytes    uint64
+	LastSeen time.Time
+}
+
+// flowDetailLRU accumulates per-(prefix, remote port, proto) byte counts and
+// last-seen times, independent of groupBy, so the TUI drill-down has
+// something to show no matter which aggregation mode is active. It's
+// capacity-bounded the same way geoLRU is, since a long-running capture
+// would otherwise grow it by one entry per distinct remote port forever.
+type flowDetailLRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[flowDetailKey]*list.Element
+}
+
+type flowDetailLRUEntry struct {
+	key   flowDetailKey
+	value *flowDetail
+}
+
+func newFlowDetailLRU(capacity int) *flowDetailLRU {
+	return &flowDetailLRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[flowDetailKey]*list.Element),
+	}
+}
+
+// Upsert adds bytes to key's running total and marks it as most recently
+// used, evicting the least recently used entry if the cache is full.
+func (c *flowDetailLRU) Upsert(key flowDetailKey, bytes uint64, lastSeen time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*flowDetailLRUEntry)
+		entry.value.Bytes += bytes
+		entry.value.LastSeen = lastSeen
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&flowDetailLRUEntry{key: key, value: &flowDetail{Bytes: bytes, LastSeen: lastSeen}})
+	c.items[key] = el
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*flowDetailLRUEntry).key)
+		}
+	}
+}
+
+// ForPrefix returns the known remote (port, proto) flows for prefix,
+// largest first, for the TUI's Enter drill-down.
+func (c *flowDetailLRU) ForPrefix(prefix string) []flowDetailView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var views []flowDetailView
+	for key, el := range c.items {
+		if key.Prefix != prefix {
+			continue
+		}
+		entry := el.Value.(*flowDetailLRUEntry)
+		views = append(views, flowDetailView{Port: key.Port, Proto: key.Proto, Bytes: entry.value.Bytes, LastSeen: entry.value.LastSeen})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Bytes > views[j].Bytes })
+	return views
+}
+
+var flowDetails = newFlowDetailLRU(4096)
+
+// flowDetailView is a flowDetails entry flattened for display.
+type flowDetailView struct {
+	Port     uint16
+	Proto    string
+	Bytes    uint64
+	LastSeen time.Time
+}
+
+// FlowsForPrefix returns the known remote (port, proto) flows for prefix,
+// largest first, for the TUI's Enter drill-down.
+func FlowsForPrefix(prefix string) []flowDetailView {
+	return flowDetails.ForPrefix(prefix)
+}
+
+// refreshFlowProcessLoop keeps flowProcess current for -by-process mode.
+func refreshFlowProcessLoop() {
+	refreshFlowProcessMap()
+	for {
+		time.Sleep(2 * time.Second)
+		refreshFlowProcessMap()
+	}
+}
+
+func refreshFlowProcessMap() {
+	newMap := make(map[flowKey]processInfo)
+	acceptAll := func(*netstat.SockTabEntry) bool { return true }
+
+	add := func(tabs []netstat.SockTabEntry, proto string) {
+		for _, tab := range tabs {
+			if tab.Process == nil {
+				continue
+			}
+			key := flowKey{
+				LocalIP:    tab.LocalAddr.IP.String(),
+				LocalPort:  tab.LocalAddr.Port,
+				RemoteIP:   tab.RemoteAddr.IP.String(),
+				RemotePort: tab.RemoteAddr.Port,
+				Proto:      proto,
 			}
+			newMap[key] = processInfo{
+				PID:  tab.Process.Pid,
+				UID:  tab.UID,
+				Name: tab.Process.Name,
+			}
+		}
+	}
+
+	if tabs, err := netstat.TCPSocks(acceptAll); err == nil {
+		add(tabs, "TCP")
+	}
+	if tabs, err := netstat.TCP6Socks(acceptAll); err == nil {
+		add(tabs, "TCP")
+	}
+	if tabs, err := netstat.UDPSocks(acceptAll); err == nil {
+		add(tabs, "UDP")
+	}
+	if tabs, err := netstat.UDP6Socks(acceptAll); err == nil {
+		add(tabs, "UDP")
+	}
+
+	flowProcessLock.Lock()
+	flowProcess = newMap
+	flowProcessLock.Unlock()
+}
+
+// lookupProcess resolves the process owning the packet's flow. out tells it
+// which side of the packet is ours, since flowProcess keys flows by
+// (local, remote) the same way the socket table does.
+func lookupProcess(packet gopacket.Packet, out bool) (processInfo, bool) {
+	var srcIP, dstIP net.IP
+	if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+		ip, _ := ipLayer.(*layers.IPv4)
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+	} else if ipLayer := packet.Layer(layers.LayerTypeIPv6); ipLayer != nil {
+		ip, _ := ipLayer.(*layers.IPv6)
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+	} else {
+		return processInfo{}, false
+	}
+
+	proto, srcPort, dstPort := "", uint16(0), uint16(0)
+	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
+		tcp, _ := tcpLayer.(*layers.TCP)
+		proto, srcPort, dstPort = "TCP", uint16(tcp.SrcPort), uint16(tcp.DstPort)
+	} else if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		udp, _ := udpLayer.(*layers.UDP)
+		proto, srcPort, dstPort = "UDP", uint16(udp.SrcPort), uint16(udp.DstPort)
+	} else {
+		return processInfo{}, false
+	}
+
+	key := flowKey{Proto: proto}
+	if out {
+		key.LocalIP, key.LocalPort = srcIP.String(), srcPort
+		key.RemoteIP, key.RemotePort = dstIP.String(), dstPort
+	} else {
+		key.LocalIP, key.LocalPort = dstIP.String(), dstPort
+		key.RemoteIP, key.RemotePort = srcIP.String(), srcPort
+	}
+
+	flowProcessLock.RLock()
+	info, ok := flowProcess[key]
+	flowProcessLock.RUnlock()
+	return info, ok
+}
+
+// printTopProcesses is the -by-process counterpart of printTopValues: top
+// processes by bytes, each with its top destination prefixes.
+func printTopProcesses() {
+	type procEntry struct {
+		Name  string
+		Total uint64
+		Dest  map[string]uint64
+	}
+
+	procStatLock.Lock()
+	entries := make([]procEntry, 0, len(procBytes))
+	for name, total := range procBytes {
+		entries = append(entries, procEntry{Name: name, Total: total, Dest: procDestBytes[name]})
+	}
+	procStatLock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Total > entries[j].Total })
+
+	top := *topShow
+	if len(entries) < top {
+		top = len(entries)
+	}
+
+	for i := 0; i < top; i++ {
+		e := entries[i]
+		fmt.Printf("%s: %s\n", e.Name, humanize.IBytes(e.Total))
+
+		destKeys := make([]string, 0, len(e.Dest))
+		for dest := range e.Dest {
+			destKeys = append(destKeys, dest)
+		}
+		sort.Slice(destKeys, func(i, j int) bool { return e.Dest[destKeys[i]] > e.Dest[destKeys[j]] })
+
+		destTop := 3
+		if len(destKeys) < destTop {
+			destTop = len(destKeys)
+		}
+		for j := 0; j < destTop; j++ {
+			dest := destKeys[j]
+			fmt.Printf("    -> %s: %s\n", dest, humanize.IBytes(e.Dest[dest]))
+		}
+	}
+}
+
+func printTopValues(now time.Time) {
+	entries := globalStats.Snapshot(now)
+	for _, e := range entries {
+		connection := ""
+		if !*noNetstat && e.ActiveConns > 0 {
+			activeString := fmt.Sprintf(" (active, %d)", e.ActiveConns)
+			connection = fmt.Sprintf("%s%s%s", boldStart, activeString, boldEnd)
 		}
 
-		fmt.Printf("%s[%s]%s: %s (%s/s)\n", key, ipLocation, connection, humanize.IBytes(total), humanize.IBytes(delta[key]/uint64(duration.Seconds())))
+		fmt.Printf("%s[%s]%s: %s (%s/s)\n", e.Key, e.Geo, connection, humanize.IBytes(e.Total), humanize.IBytes(e.BytesPerSec))
 	}
 }
 
@@ -201,13 +1131,33 @@ func printStats() {
 	printTimestamp = time.Now()
 	for {
 		time.Sleep(5 * time.Second)
-		printTopValues()
+		if processModeEnabled() {
+			printTopProcesses()
+		} else {
+			printTopValues(time.Now())
+		}
 		fmt.Println()
 	}
 }
 
+// statsTicker rolls the accumulated delta into totals every 5s, the same
+// cadence as printStats, without printing anything. It's used instead of
+// printStats when the TUI owns the terminal, since the HTTP endpoints and
+// the TUI both read Stats.Cached() and still need it refreshed on a clock.
+func statsTicker() {
+	printTimestamp = time.Now()
+	for {
+		time.Sleep(5 * time.Second)
+		globalStats.Snapshot(time.Now())
+	}
+}
+
 func loop(info InterfaceInfo, packetSource *gopacket.PacketSource) {
 	for packet := range packetSource.Packets() {
+		if offlineMode {
+			advanceOfflineWindow(packet.Metadata().Timestamp)
+		}
+
 		var linkFlow gopacket.Flow
 		var networkFlow gopacket.Flow
 		linkLayer := packet.LinkLayer()
@@ -222,39 +1172,341 @@ func loop(info InterfaceInfo, packetSource *gopacket.PacketSource) {
 		}
 
 		out := isOutbound(info, linkFlow, networkFlow)
-		if (out && !*useInbound) || (!out && *useInbound) {
-			var resIP netip.Addr
-			len := 0
-			if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
-				ip, _ := ipLayer.(*layers.IPv4)
-				if !*useInbound {
-					resIP, _ = netip.AddrFromSlice(ip.DstIP)
-				} else {
-					resIP, _ = netip.AddrFromSlice(ip.SrcIP)
-				}
-				len = int(ip.Length) + 40
-			}
-			if ipLayer := packet.Layer(layers.LayerTypeIPv6); ipLayer != nil {
-				ip, _ := ipLayer.(*layers.IPv6)
-				if !*useInbound {
-					resIP, _ = netip.AddrFromSlice(ip.DstIP)
-				} else {
-					resIP, _ = netip.AddrFromSlice(ip.SrcIP)
-				}
-				len = int(ip.Length) + 40
+		switch currentDirection() {
+		case DirectionOutbound:
+			if !out {
+				continue
 			}
-			if len == 0 {
+		case DirectionInbound:
+			if out {
 				continue
 			}
-			resIPPrefix := getIPPrefixString(resIP)
-			// log.Printf("Outbound packet to %s, %d bytes\n", destIP, len)
-			statLock.Lock()
-			deltaStats[resIPPrefix] += uint64(len)
-			statLock.Unlock()
+		}
+
+		var resIP netip.Addr
+		len := 0
+		if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+			ip, _ := ipLayer.(*layers.IPv4)
+			if out {
+				resIP, _ = netip.AddrFromSlice(ip.DstIP)
+			} else {
+				resIP, _ = netip.AddrFromSlice(ip.SrcIP)
+			}
+			len = int(ip.Length) + 40
+		}
+		if ipLayer := packet.Layer(layers.LayerTypeIPv6); ipLayer != nil {
+			ip, _ := ipLayer.(*layers.IPv6)
+			if out {
+				resIP, _ = netip.AddrFromSlice(ip.DstIP)
+			} else {
+				resIP, _ = netip.AddrFromSlice(ip.SrcIP)
+			}
+			len = int(ip.Length) + 40
+		}
+		if len == 0 {
+			continue
+		}
+
+		resIPPrefix := getIPPrefixString(resIP)
+		proto, port := remoteProtoPort(packet, out)
+
+		key := StatKey{Prefix: resIPPrefix}
+		switch currentGroupBy() {
+		case GroupByProto:
+			key = StatKey{Proto: proto}
+		case GroupByIPPort:
+			key.Proto = proto
+			key.Port = port
+		case GroupByASN:
+			asn := lookupGeo(resIPPrefix).asn
+			if asn == "" {
+				asn = "unknown"
+			}
+			key = StatKey{ASN: asn}
+		}
+
+		// log.Printf("Outbound packet to %s, %d bytes\n", destIP, len)
+		statLock.Lock()
+		deltaStats[key] += uint64(len)
+		statLock.Unlock()
+
+		if proto != "" {
+			fk := flowDetailKey{Prefix: resIPPrefix, Port: port, Proto: proto}
+			flowDetails.Upsert(fk, uint64(len), packet.Metadata().Timestamp)
+		}
+
+		if processModeEnabled() {
+			procName := "unknown"
+			if info, ok := lookupProcess(packet, out); ok {
+				procName = fmt.Sprintf("%d:%s", info.PID, info.Name)
+			}
+			procStatLock.Lock()
+			procBytes[procName] += uint64(len)
+			if procDestBytes[procName] == nil {
+				procDestBytes[procName] = make(map[string]uint64)
+			}
+			procDestBytes[procName][resIPPrefix] += uint64(len)
+			procStatLock.Unlock()
+		}
+	}
+}
+
+// remoteProtoPort decodes the TCP/UDP layer of packet and returns the
+// transport protocol name and the remote side's port: out tells it which
+// side of this particular packet is ours, the same way lookupProcess does,
+// so it stays correct even when both directions are being counted together
+// (DirectionBoth).
+func remoteProtoPort(packet gopacket.Packet, out bool) (proto string, port uint16) {
+	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
+		tcp, _ := tcpLayer.(*layers.TCP)
+		if out {
+			return "TCP", uint16(tcp.DstPort)
+		}
+		return "TCP", uint16(tcp.SrcPort)
+	}
+	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		udp, _ := udpLayer.(*layers.UDP)
+		if out {
+			return "UDP", uint16(udp.DstPort)
+		}
+		return "UDP", uint16(udp.SrcPort)
+	}
+	return "", 0
+}
+
+// statusResponse is the JSON body of GET /status.
+type statusResponse struct {
+	Interface string           `json:"interface"`
+	Uptime    float64          `json:"uptime"`
+	Top       []statusTopEntry `json:"top"`
+}
+
+type statusTopEntry struct {
+	Prefix      string `json:"prefix"`
+	BytesTotal  uint64 `json:"bytes_total"`
+	BytesPerSec uint64 `json:"bytes_per_sec"`
+	ActiveConns int    `json:"active_conns"`
+	Geo         string `json:"geo"`
+}
+
+// startHTTPServer exposes globalStats over JSON (/status) and Prometheus
+// text format (/metrics), so dashboards and alerting can consume the same
+// top-talker view as the terminal output.
+func startHTTPServer(addr, ifaceLabel string) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		entries := globalStats.Cached()
+		resp := statusResponse{
+			Interface: ifaceLabel,
+			Uptime:    time.Since(startTime).Seconds(),
+		}
+		for _, e := range entries {
+			resp.Top = append(resp.Top, statusTopEntry{
+				Prefix:      e.Key.String(),
+				BytesTotal:  e.Total,
+				BytesPerSec: e.BytesPerSec,
+				ActiveConns: e.ActiveConns,
+				Geo:         e.Geo,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("Error encoding /status response: %s\n", err)
+		}
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		entries := globalStats.Cached()
+		direction := currentDirection().String()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP chitose_bytes_total Total bytes observed per aggregation key")
+		fmt.Fprintln(w, "# TYPE chitose_bytes_total counter")
+		for _, e := range entries {
+			// e.Key.String() is the row's label under whichever -group-by
+			// mode is active (prefix, prefix:port, proto, or asn) and is
+			// unique per entries since it comes straight off the distinct
+			// StatKey the row was aggregated under. Labeling on e.Key.Prefix
+			// alone broke down outside ip/ip:port grouping: it's empty in
+			// proto/asn mode, and shared across every port of a prefix in
+			// ip:port mode, so multiple rows emitted identical label sets.
+			fmt.Fprintf(w, "chitose_bytes_total{key=%q,direction=%q} %d\n", e.Key.String(), direction, e.Total)
+		}
+
+		fmt.Fprintln(w, "# HELP chitose_active_connections Active connections observed per aggregation key")
+		fmt.Fprintln(w, "# TYPE chitose_active_connections gauge")
+		for _, e := range entries {
+			fmt.Fprintf(w, "chitose_active_connections{key=%q} %d\n", e.Key.String(), e.ActiveConns)
+		}
+	})
+
+	go func() {
+		log.Printf("HTTP status/metrics API listening on %s\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("HTTP server error: %s\n", err)
+		}
+	}()
+}
+
+// matchesFilter reports whether any of fields contains filter as a
+// case-insensitive substring; an empty filter always matches.
+func matchesFilter(filter string, fields ...string) bool {
+	if filter == "" {
+		return true
+	}
+	filter = strings.ToLower(filter)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), filter) {
+			return true
 		}
 	}
+	return false
 }
 
+// processRows renders procBytes/procDestBytes as ui.Row, one row per
+// process with its top destination folded into the Geo column, so the TUI
+// can show process-mode traffic with the same table it uses for prefixes.
+func processRows(filter string) []ui.Row {
+	procStatLock.Lock()
+	type procEntry struct {
+		Name  string
+		Total uint64
+		Dest  map[string]uint64
+	}
+	entries := make([]procEntry, 0, len(procBytes))
+	for name, total := range procBytes {
+		entries = append(entries, procEntry{Name: name, Total: total, Dest: procDestBytes[name]})
+	}
+	procStatLock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Total > entries[j].Total })
+
+	top := *topShow
+	if len(entries) < top {
+		top = len(entries)
+	}
+
+	rows := make([]ui.Row, 0, top)
+	for i := 0; i < top; i++ {
+		e := entries[i]
+
+		topDest := ""
+		var bestBytes uint64
+		for dest, b := range e.Dest {
+			if b > bestBytes {
+				bestBytes, topDest = b, dest
+			}
+		}
+		if !matchesFilter(filter, e.Name, topDest) {
+			continue
+		}
+		rows = append(rows, ui.Row{Label: e.Name, BytesTotal: e.Total, Geo: topDest})
+	}
+	return rows
+}
+
+// tuiDataSource adapts the package's global stats and control flags to
+// ui.DataSource, so internal/ui can stay ignorant of package main's types.
+type tuiDataSource struct{}
+
+func (tuiDataSource) Rows(filter string) []ui.Row {
+	if processModeEnabled() {
+		return processRows(filter)
+	}
+
+	entries := globalStats.Cached()
+	rows := make([]ui.Row, 0, len(entries))
+	for _, e := range entries {
+		label := e.Key.String()
+		if !matchesFilter(filter, label, e.Geo) {
+			continue
+		}
+		rows = append(rows, ui.Row{
+			Label:       label,
+			BytesTotal:  e.Total,
+			BytesPerSec: e.BytesPerSec,
+			ActiveConns: e.ActiveConns,
+			Geo:         e.Geo,
+		})
+	}
+	return rows
+}
+
+func (tuiDataSource) Flows(label string) []ui.FlowRow {
+	views := FlowsForPrefix(label)
+	rows := make([]ui.FlowRow, 0, len(views))
+	for _, v := range views {
+		rows = append(rows, ui.FlowRow{RemotePort: v.Port, Proto: v.Proto, LastSeen: v.LastSeen, Bytes: v.Bytes})
+	}
+	return rows
+}
+
+func (tuiDataSource) ToggleSort() {
+	sortByTotalMutex.Lock()
+	sortByTotal = !sortByTotal
+	sortByTotalMutex.Unlock()
+}
+
+func (tuiDataSource) SetDirection(d ui.Direction) {
+	switch d {
+	case ui.DirectionInbound:
+		setDirection(DirectionInbound)
+	case ui.DirectionBoth:
+		setDirection(DirectionBoth)
+	default:
+		setDirection(DirectionOutbound)
+	}
+}
+
+func (tuiDataSource) SetGroupMode(g ui.GroupMode) {
+	switch g {
+	case ui.GroupIPPort:
+		setGroupBy(GroupByIPPort)
+	case ui.GroupProcess:
+		setGroupBy(GroupByProcess)
+		ensureFlowProcessLoop()
+	case ui.GroupASN:
+		setGroupBy(GroupByASN)
+	default:
+		setGroupBy(GroupByIP)
+	}
+}
+
+// uiDirection and uiGroupMode convert the capture loop's current state to
+// the ui package's equivalents, so ui.Run can seed its header and its 'd'/
+// 'p' cycling from what -inbound/-group-by already set up instead of always
+// starting from outbound/ip. The TUI has no proto grouping, so -group-by
+// proto is reported to it as ip.
+func uiDirection() ui.Direction {
+	switch currentDirection() {
+	case DirectionInbound:
+		return ui.DirectionInbound
+	case DirectionBoth:
+		return ui.DirectionBoth
+	default:
+		return ui.DirectionOutbound
+	}
+}
+
+func uiGroupMode() ui.GroupMode {
+	switch currentGroupBy() {
+	case GroupByIPPort:
+		return ui.GroupIPPort
+	case GroupByProcess:
+		return ui.GroupProcess
+	case GroupByASN:
+		return ui.GroupASN
+	default:
+		return ui.GroupIP
+	}
+}
+
+// handleRawInput is the -output plain keyboard handler: a raw-mode reader
+// supporting only the 's' sort toggle. It's the fallback for non-TTY stdout
+// (logs, pipes) and for -output plain; everywhere else ui.Run owns the
+// keyboard with the full set of bindings (sort, direction, grouping,
+// filter, drill-down, quit).
 func handleRawInput() {
 	oldState, err := makeRaw(int(os.Stdin.Fd()))
 	if err != nil {
@@ -291,54 +1543,178 @@ func handleRawInput() {
 }
 
 func main() {
-	deltaStats = make(map[string]uint64)
-	sizeStats = make(map[string]uint64)
-	iface := flag.String("i", "eth0", "Interface to listen on")
+	deltaStats = make(map[StatKey]uint64)
+	sizeStats = make(map[StatKey]uint64)
+	procBytes = make(map[string]uint64)
+	procDestBytes = make(map[string]map[string]uint64)
+	iface := flag.String("i", "", "Interface(s) to listen on: a name, a comma-separated list, or \"any\" (default: auto-select)")
+	list := flag.Bool("list", false, "List available capture interfaces and exit")
+	offlineSource := flag.String("r", "", "Read packets from a pcap/pcapng file, or a directory of them, instead of capturing live from -i")
+	selfIPs := flag.String("self-ip", "", "Comma-separated local IP addresses to treat as our side (used with -r, which has no live interface)")
+	selfCIDRs := flag.String("self-cidr", "", "Comma-separated local CIDR prefixes to treat as our side (used with -r, which has no live interface)")
+	bpfFilter := flag.String("f", "", "BPF filter expression applied to captured packets")
+	groupByFlag := flag.String("group-by", "ip", "Aggregation key: ip, ip:port, proto, asn, or process")
+	httpAddr := flag.String("http", "", "Listen address for the HTTP status/metrics API (e.g. :9090); disabled when empty")
 	topShow = flag.Int("top", 10, "Number of top values to show")
 	noNetstat = flag.Bool("no-netstat", false, "Do not detect active connections")
 	useInbound = flag.Bool("inbound", false, "Show inbound traffic instead of outbound")
+	byProcess = flag.Bool("by-process", false, "Show top processes by traffic instead of top prefixes")
 	sortDelta := flag.Bool("sort-delta", false, "Sort by delta instead of total")
-	ipdbPath := flag.String("ipdb", "", "IPDB format database file (default \"\" for no IPDB)")
+	geoIPDBPath := flag.String("geo-ipdb", "", "ipipdotnet/ipdb-go City database file")
+	geoMMDBPath := flag.String("geo-mmdb", "", "MaxMind GeoLite2-City.mmdb file")
+	geoASNPath := flag.String("geo-asn", "", "MaxMind GeoLite2-ASN.mmdb file (stacks with -geo-ipdb or -geo-mmdb)")
+	outputMode := flag.String("output", "", "Output mode: \"plain\" for the scrolling terminal printer (previous behavior). Default is the full-screen TUI when stdout is a terminal, falling back to plain otherwise")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Println()
-		fmt.Println("Press 's' (lowercase) to change sort order")
+		fmt.Println("On a terminal, the full-screen UI is used by default:")
+		fmt.Println("  s sort, d direction, p group-by, / filter, Enter drill down, q quit")
+		fmt.Println("Pass -output plain for the scrolling printer instead, which only supports 's'")
 	}
 	flag.Parse()
 	sortByTotal = !*sortDelta
+	groupBy = parseGroupBy(*groupByFlag)
+	if *useInbound {
+		captureDirection = DirectionInbound
+	}
+	startTime = time.Now()
+
+	plainOutput := *outputMode == "plain" || !term.IsTerminal(int(os.Stdout.Fd()))
 
-	handle, err := pcap.OpenLive(*iface, 72, false, 1000)
+	if *list {
+		printDeviceList()
+		return
+	}
+
+	if processModeEnabled() {
+		ensureFlowProcessLoop()
+	}
+
+	geo = buildGeoProvider(*geoIPDBPath, *geoMMDBPath, *geoASNPath)
+
+	if *offlineSource != "" {
+		runOffline(*offlineSource, *selfIPs, *selfCIDRs, *bpfFilter, *httpAddr)
+		return
+	}
+
+	names, err := resolveInterfaceNames(*iface)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ifaceInfo, err := getInterfaceAddrs(*iface)
+	ifaceInfos, err := getInterfacesAddrs(names)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if ifaceInfo.MAC != nil {
-		log.Printf("MAC: %s\n", ifaceInfo.MAC)
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		info := ifaceInfos[name]
+		if info.MAC != nil {
+			log.Printf("[%s] MAC: %s\n", name, info.MAC)
+		}
+		for _, ip := range info.IPs {
+			log.Printf("[%s] IP: %s\n", name, ip)
+		}
+
+		handle, err := pcap.OpenLive(name, 72, false, 1000)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyBPFFilter(handle, *bpfFilter)
+
+		linkType := handle.LinkType()
+		log.Printf("[%s] Handle link type: %s (%d)\n", name, linkType.String(), linkType)
+
+		packetSource := gopacket.NewPacketSource(handle, linkType)
+
+		wg.Add(1)
+		go func(info InterfaceInfo, packetSource *gopacket.PacketSource) {
+			defer wg.Done()
+			loop(info, packetSource)
+		}(info, packetSource)
 	}
-	for _, ip := range ifaceInfo.IPs {
-		log.Printf("IP: %s\n", ip)
+
+	if *httpAddr != "" {
+		startHTTPServer(*httpAddr, strings.Join(names, ","))
 	}
 
-	linkType := handle.LinkType()
-	log.Printf("Handle link type: %s (%d)\n", linkType.String(), linkType)
+	fmt.Println("Starting...")
+	if plainOutput {
+		go handleRawInput()
+		go printStats()
+	} else {
+		go statsTicker()
+		go func() {
+			cfg := ui.Config{
+				RefreshInterval:  time.Second,
+				InitialDirection: uiDirection(),
+				InitialGroupMode: uiGroupMode(),
+			}
+			if err := ui.Run(tuiDataSource{}, cfg); err != nil {
+				log.Printf("TUI error: %s\n", err)
+			}
+			os.Exit(0)
+		}()
+	}
+	wg.Wait()
+}
 
-	packetSource := gopacket.NewPacketSource(handle, linkType)
-	// totalBytes := 0
+// runOffline replays one or more pcap/pcapng files given by -r instead of
+// capturing live from an interface. Aggregation windows are driven by packet
+// timestamps rather than wall clock, and a final summary is printed at EOF
+// instead of looping forever.
+func runOffline(source, selfIPs, selfCIDRs, bpfFilter, httpAddr string) {
+	offlineMode = true
 
-	db, err = ipdb.NewCity(*ipdbPath)
+	if httpAddr != "" {
+		startHTTPServer(httpAddr, source)
+	}
+
+	files, err := listOfflineSources(source)
 	if err != nil {
-		log.Printf("Error opening IPDB: %s\n", err)
-		db = nil
-		log.Println("Continuing without IPDB")
+		log.Fatal(err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("No pcap/pcapng files found at %s\n", source)
 	}
 
-	fmt.Println("Starting...")
-	go handleRawInput()
-	go printStats()
-	loop(ifaceInfo, packetSource)
+	ifaceInfo := selfAddrInfo(selfIPs, selfCIDRs)
+	for _, ip := range ifaceInfo.IPs {
+		log.Printf("Self IP: %s\n", ip)
+	}
+	for _, ipNet := range ifaceInfo.Nets {
+		log.Printf("Self CIDR: %s\n", ipNet)
+	}
+	if len(ifaceInfo.IPs) == 0 && len(ifaceInfo.Nets) == 0 {
+		log.Println("Warning: -r given without -self-ip/-self-cidr, so isOutbound can't tell which side is ours; capturing both directions instead of outbound-only")
+		setDirection(DirectionBoth)
+	}
+
+	fmt.Println("Starting offline analysis...")
+	for _, file := range files {
+		handle, err := pcap.OpenOffline(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyBPFFilter(handle, bpfFilter)
+		log.Printf("Reading %s\n", file)
+
+		linkType := handle.LinkType()
+		packetSource := gopacket.NewPacketSource(handle, linkType)
+		loop(ifaceInfo, packetSource)
+		handle.Close()
+	}
+
+	if lastWindowTime.IsZero() {
+		lastWindowTime = time.Now()
+	}
+	fmt.Println()
+	fmt.Println("Final summary:")
+	if processModeEnabled() {
+		printTopProcesses()
+	} else {
+		printTopValues(lastWindowTime)
+	}
 }